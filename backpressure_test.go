@@ -0,0 +1,79 @@
+package mjpeg
+
+import "testing"
+
+func newTestClient(bufferSize int) *client {
+	return &client{
+		frames: make(chan frameData, bufferSize),
+		evict:  make(chan struct{}),
+	}
+}
+
+func testFrame(jpeg string) frameData {
+	return frameData{jpeg: []byte(jpeg)}
+}
+
+func TestSendDropOldest(t *testing.T) {
+	s := &Stream{BackpressurePolicy: DropOldest}
+	c := newTestClient(1)
+
+	s.send(c, testFrame("frame-1"))
+	s.send(c, testFrame("frame-2"))
+
+	if got := string((<-c.frames).jpeg); got != "frame-2" {
+		t.Errorf("Expected newest frame to survive, got %q", got)
+	}
+	if c.framesDropped != 1 {
+		t.Errorf("Expected 1 dropped frame, got %d", c.framesDropped)
+	}
+}
+
+func TestSendDropNewest(t *testing.T) {
+	s := &Stream{BackpressurePolicy: DropNewest}
+	c := newTestClient(1)
+
+	s.send(c, testFrame("frame-1"))
+	s.send(c, testFrame("frame-2"))
+
+	if got := string((<-c.frames).jpeg); got != "frame-1" {
+		t.Errorf("Expected oldest frame to survive, got %q", got)
+	}
+	if c.framesDropped != 1 {
+		t.Errorf("Expected 1 dropped frame, got %d", c.framesDropped)
+	}
+}
+
+func TestSendCloseOnBackpressure(t *testing.T) {
+	s := &Stream{BackpressurePolicy: CloseOnBackpressure, m: map[*client]bool{}}
+	c := newTestClient(1)
+	s.m[c] = true
+
+	s.send(c, testFrame("frame-1"))
+	s.send(c, testFrame("frame-2"))
+
+	select {
+	case <-c.evict:
+	default:
+		t.Error("Expected client to be evicted once its buffer filled up")
+	}
+	if _, ok := s.m[c]; ok {
+		t.Error("Expected evicted client to be removed from the Stream")
+	}
+}
+
+func TestClientsSnapshot(t *testing.T) {
+	s := NewStream()
+	c := newTestClient(2)
+	c.addr = "10.0.0.1:1234"
+	s.m[c] = true
+
+	s.send(c, testFrame("frame-1"))
+
+	stats := s.Clients()
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 client, got %d", len(stats))
+	}
+	if stats[0].Addr != "10.0.0.1:1234" {
+		t.Errorf("Expected addr %q, got %q", "10.0.0.1:1234", stats[0].Addr)
+	}
+}
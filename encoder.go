@@ -0,0 +1,138 @@
+package mjpeg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// frameData is the raw material queued per client. Each Encoder renders it
+// into wire bytes immediately before the frame is written, so the same
+// buffered frame can feed several transports without pre-rendering for each
+// of them up front.
+type frameData struct {
+	jpeg    []byte
+	elapsed time.Duration
+	seq     uint64
+}
+
+// Encoder formats JPEG frames for a particular wire protocol, so a Stream
+// can serve alternative transports without duplicating its fan-out logic.
+// Register an Encoder with a handler using Stream.HandlerFor.
+type Encoder interface {
+	// ContentType is the value of the Content-Type header a plain-HTTP
+	// handler using this Encoder should send before writing any frames.
+	// Encoders that don't use HTTP response framing (e.g. WebSocket) return
+	// "": HandlerFor detects this and upgrades the connection instead.
+	ContentType() string
+	// Encode formats a single frame for the wire.
+	Encode(f frameData) []byte
+}
+
+// EncoderMultipart is the original multipart/x-mixed-replace encoder: each
+// part is preceded by a blank line, the boundary, Content-Type and
+// Content-Length headers, and an X-Timestamp extension header giving the
+// time elapsed since the stream started. This is the Encoder ServeHTTP uses.
+var EncoderMultipart Encoder = multipartEncoder{}
+
+type multipartEncoder struct{}
+
+func (multipartEncoder) ContentType() string {
+	return "multipart/x-mixed-replace;boundary=" + boundaryWord
+}
+
+func (multipartEncoder) Encode(f frameData) []byte {
+	header := multipartHeader(f.jpeg, f.elapsed)
+	frame := make([]byte, 0, len(header)+len(f.jpeg))
+	frame = append(frame, header...)
+	frame = append(frame, f.jpeg...)
+	return frame
+}
+
+func multipartHeader(jpeg []byte, elapsed time.Duration) string {
+	sec := int64(elapsed.Seconds())
+	usec := int64(elapsed.Microseconds() % 1e6)
+	return fmt.Sprintf(headerf, len(jpeg), sec, usec)
+}
+
+// EncoderJPEGMultipart is a stricter multipart/x-mixed-replace encoder for
+// clients that reject the leading CRLF or the non-standard X-Timestamp
+// header that EncoderMultipart sends: each part is just the boundary,
+// Content-Type and Content-Length headers, followed by the JPEG bytes.
+var EncoderJPEGMultipart Encoder = jpegMultipartEncoder{}
+
+type jpegMultipartEncoder struct{}
+
+func (jpegMultipartEncoder) ContentType() string {
+	return "multipart/x-mixed-replace;boundary=" + boundaryWord
+}
+
+const jpegMultipartHeaderf = "--" + boundaryWord + "\r\n" +
+	"Content-Type: image/jpeg\r\n" +
+	"Content-Length: %d\r\n" +
+	"\r\n"
+
+func (jpegMultipartEncoder) Encode(f frameData) []byte {
+	header := fmt.Sprintf(jpegMultipartHeaderf, len(f.jpeg))
+	frame := make([]byte, 0, len(header)+len(f.jpeg))
+	frame = append(frame, header...)
+	frame = append(frame, f.jpeg...)
+	return frame
+}
+
+// EncoderWebSocket ships each JPEG as a single WebSocket binary frame: a
+// two-byte big-endian length, a JSON header giving the frame's elapsed time
+// and sequence number, and the raw JPEG bytes. Use it with
+// stream.HandlerFor(EncoderWebSocket).
+var EncoderWebSocket Encoder = websocketEncoder{}
+
+// websocketFrameHeader is the JSON header written ahead of the JPEG bytes in
+// each WebSocket binary frame.
+type websocketFrameHeader struct {
+	Timestamp float64 `json:"timestamp"`
+	Seq       uint64  `json:"seq"`
+}
+
+type websocketEncoder struct{}
+
+func (websocketEncoder) ContentType() string {
+	return ""
+}
+
+func (websocketEncoder) Encode(f frameData) []byte {
+	header, _ := json.Marshal(websocketFrameHeader{
+		Timestamp: f.elapsed.Seconds(),
+		Seq:       f.seq,
+	})
+
+	payload := make([]byte, 0, 2+len(header)+len(f.jpeg))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(header)))
+	payload = append(payload, header...)
+	payload = append(payload, f.jpeg...)
+
+	return wsBinaryFrame(payload)
+}
+
+const wsOpBinary = 0x82 // FIN + binary opcode, unmasked server-to-client frame
+
+// wsBinaryFrame wraps payload in a single, unmasked RFC 6455 binary frame,
+// as sent by a server to a client.
+func wsBinaryFrame(payload []byte) []byte {
+	var header []byte
+	switch {
+	case len(payload) <= 125:
+		header = []byte{wsOpBinary, byte(len(payload))}
+	case len(payload) <= 0xffff:
+		header = make([]byte, 4)
+		header[0] = wsOpBinary
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = make([]byte, 10)
+		header[0] = wsOpBinary
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	return append(header, payload...)
+}
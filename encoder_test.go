@@ -0,0 +1,52 @@
+package mjpeg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJPEGMultipartEncoder(t *testing.T) {
+	f := frameData{jpeg: []byte("test_frame")}
+	frame := EncoderJPEGMultipart.Encode(f)
+
+	expected := "--MJPEGBOUNDARY\r\n" +
+		"Content-Type: image/jpeg\r\n" +
+		"Content-Length: 10\r\n" +
+		"\r\n" +
+		"test_frame"
+
+	if string(frame) != expected {
+		t.Errorf("Expected frame %q, got %q", expected, string(frame))
+	}
+}
+
+func TestWebSocketEncoder(t *testing.T) {
+	f := frameData{jpeg: []byte("test_frame"), elapsed: 2500 * time.Millisecond, seq: 7}
+	frame := EncoderWebSocket.Encode(f)
+
+	if frame[0] != 0x82 {
+		t.Fatalf("Expected a FIN binary opcode byte, got %#x", frame[0])
+	}
+
+	payloadLen := int(frame[1])
+	payload := frame[2 : 2+payloadLen]
+
+	headerLen := binary.BigEndian.Uint16(payload[:2])
+	var header websocketFrameHeader
+	if err := json.Unmarshal(payload[2:2+headerLen], &header); err != nil {
+		t.Fatalf("Failed to unmarshal header: %v", err)
+	}
+	if header.Seq != 7 {
+		t.Errorf("Expected seq 7, got %d", header.Seq)
+	}
+	if header.Timestamp != 2.5 {
+		t.Errorf("Expected timestamp 2.5, got %v", header.Timestamp)
+	}
+
+	jpeg := payload[2+headerLen:]
+	if string(jpeg) != "test_frame" {
+		t.Errorf("Expected jpeg bytes %q, got %q", "test_frame", string(jpeg))
+	}
+}
@@ -0,0 +1,297 @@
+//go:build rtsp
+
+// Package rtsp is an optional adapter that ingests video from an RTSP camera,
+// JPEG-encodes the decoded frames, and republishes them on an mjpeg.Stream so
+// cameras that only speak RTSP can be viewed as an ordinary MJPEG feed.
+//
+// It depends on FFmpeg via cgo, so it is kept out of the core mjpeg module
+// and built only with `-tags rtsp` (and a working FFmpeg/cgo toolchain).
+package rtsp
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/cgo/ffmpeg"
+	"github.com/deepch/vdk/format/rtsp"
+	"golang.org/x/image/draw"
+
+	"github.com/hybridgroup/mjpeg"
+)
+
+// Options configures a Source.
+type Options struct {
+	// FPS caps the rate at which frames are re-encoded and published. Zero
+	// means "publish every decoded frame".
+	FPS float64
+
+	// MaxWidth and MaxHeight downscale decoded frames that exceed them,
+	// preserving aspect ratio. Zero disables downscaling on that axis.
+	MaxWidth  int
+	MaxHeight int
+
+	// Quality is the JPEG encoding quality, 1-100. Defaults to 75.
+	Quality int
+
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts.
+	// They default to 1s and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Quality == 0 {
+		o.Quality = 75
+	}
+	if o.MinBackoff == 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+	return o
+}
+
+// Source pulls video from an RTSP URL, JPEG-encodes each frame, and publishes
+// it to an attached mjpeg.Stream.
+type Source struct {
+	URL  string
+	opts Options
+
+	stop chan struct{}
+	done chan struct{}
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewRTSPSource initializes and returns a new Source for the given RTSP URL.
+// Call Attach to start reading and publish decoded frames to a Stream.
+func NewRTSPSource(url string, opts Options) *Source {
+	return &Source{
+		URL:  url,
+		opts: opts.withDefaults(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Attach starts the Source, reconnecting with backoff until Stop is called,
+// and publishes every frame it decodes to stream. Calling Attach more than
+// once has no additional effect: only the first call starts the read
+// goroutine.
+func (s *Source) Attach(stream *mjpeg.Stream) *Source {
+	s.startOnce.Do(func() { go s.run(stream) })
+	return s
+}
+
+// Stop stops the Source and waits for its goroutine to exit. It is safe to
+// call Stop even if Attach was never called.
+func (s *Source) Stop() {
+	s.startOnce.Do(func() { close(s.done) })
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// Err returns the last error encountered while reading from the upstream
+// camera, if any.
+func (s *Source) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Source) setErr(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *Source) run(stream *mjpeg.Stream) {
+	defer close(s.done)
+
+	backoff := s.opts.MinBackoff
+	for {
+		if err := s.readOnce(stream); err != nil {
+			s.setErr(err)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+}
+
+func (s *Source) readOnce(stream *mjpeg.Stream) error {
+	session, err := rtsp.Dial(s.URL)
+	if err != nil {
+		return fmt.Errorf("rtsp: dial %s: %w", s.URL, err)
+	}
+	var closeOnce sync.Once
+	closeSession := func() { closeOnce.Do(func() { session.Close() }) }
+	defer closeSession()
+
+	// ReadPacket below blocks on the network with no way to pass it a
+	// context, so the only way to interrupt it when Stop is called is to
+	// close the session out from under it from a second goroutine.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stop:
+			closeSession()
+		case <-done:
+		}
+	}()
+
+	codecs, err := session.Streams()
+	if err != nil {
+		return fmt.Errorf("rtsp: reading stream codecs: %w", err)
+	}
+
+	decoder, videoIdx, err := newVideoDecoder(codecs)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	var lastPublish time.Time
+	interval := fpsToInterval(s.opts.FPS)
+
+	for {
+		select {
+		case <-s.stop:
+			return nil
+		default:
+		}
+
+		pkt, err := session.ReadPacket()
+		if err != nil {
+			if stopped(s.stop) {
+				return nil
+			}
+			return fmt.Errorf("rtsp: reading packet: %w", err)
+		}
+		if pkt.Idx != videoIdx {
+			continue
+		}
+
+		img, err := decoder.Decode(pkt)
+		if err != nil || img == nil {
+			continue
+		}
+		if interval > 0 && time.Since(lastPublish) < interval {
+			continue
+		}
+		lastPublish = time.Now()
+
+		img = downscale(img, s.opts.MaxWidth, s.opts.MaxHeight)
+
+		jpegBytes, err := encodeJPEG(img, s.opts.Quality)
+		if err != nil {
+			continue
+		}
+		stream.UpdateJPEG(jpegBytes)
+	}
+}
+
+// stopped reports whether stop has been closed, without blocking.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// videoDecoder wraps the cgo FFmpeg decoder so the rest of the package only
+// has to deal in image.Image.
+type videoDecoder struct {
+	dec *ffmpeg.VideoDecoder
+}
+
+func newVideoDecoder(codecs []av.CodecData) (*videoDecoder, int, error) {
+	for i, codec := range codecs {
+		if !codec.Type().IsVideo() {
+			continue
+		}
+		dec, err := ffmpeg.NewVideoDecoder(codec.(av.VideoCodecData))
+		if err != nil {
+			return nil, 0, fmt.Errorf("rtsp: creating video decoder: %w", err)
+		}
+		return &videoDecoder{dec: dec}, i, nil
+	}
+	return nil, 0, fmt.Errorf("rtsp: no video stream found")
+}
+
+func (d *videoDecoder) Decode(pkt av.Packet) (image.Image, error) {
+	frame, gotFrame, err := d.dec.Decode(pkt.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !gotFrame {
+		return nil, nil
+	}
+	return frame.Image()
+}
+
+func (d *videoDecoder) Close() {
+	d.dec.Close()
+}
+
+func fpsToInterval(fps float64) time.Duration {
+	if fps <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// downscale resizes img to fit within maxWidth x maxHeight, preserving
+// aspect ratio. A zero bound on an axis disables downscaling on that axis;
+// img is returned unchanged if it is already within bounds.
+func downscale(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if (maxWidth == 0 || w <= maxWidth) && (maxHeight == 0 || h <= maxHeight) {
+		return img
+	}
+
+	scale := 1.0
+	if maxWidth > 0 {
+		if s := float64(maxWidth) / float64(w); s < scale {
+			scale = s
+		}
+	}
+	if maxHeight > 0 {
+		if s := float64(maxHeight) / float64(h); s < scale {
+			scale = s
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, int(float64(w)*scale), int(float64(h)*scale)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
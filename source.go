@@ -0,0 +1,251 @@
+package mjpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame is a single JPEG frame read from an upstream MJPEG source.
+type Frame struct {
+	JPEG      []byte
+	Timestamp time.Time
+}
+
+// Source connects to a remote multipart/x-mixed-replace MJPEG endpoint and
+// yields the JPEG frames it produces. A Source keeps exactly one upstream
+// connection open no matter how many consumers read from Frames, and
+// reconnects with backoff whenever the upstream connection drops.
+type Source struct {
+	// URL is the upstream MJPEG endpoint, e.g. "http://camera.local/video".
+	URL string
+	// Username and Password, if set, are sent as HTTP basic auth.
+	Username string
+	Password string
+	// Client is used to make the upstream request. Defaults to
+	// http.DefaultClient; set a custom client for TLS configuration or
+	// timeouts.
+	Client *http.Client
+
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts.
+	// They default to 1s and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	frames    chan Frame
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewSource initializes and returns a new Source for the given upstream URL.
+// Call Start (or Attach) to begin reading frames.
+func NewSource(url string) *Source {
+	return &Source{
+		URL:        url,
+		Client:     http.DefaultClient,
+		MinBackoff: time.Second,
+		MaxBackoff: 30 * time.Second,
+		frames:     make(chan Frame),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Frames returns the channel of JPEG frames read from the upstream source.
+func (s *Source) Frames() <-chan Frame {
+	return s.frames
+}
+
+// Start connects to the upstream source in the background, reconnecting with
+// backoff for as long as the Source runs. Calling Start more than once (or
+// calling Start after Attach, or Attach more than once) has no additional
+// effect: only the first call starts the read goroutine.
+func (s *Source) Start() {
+	s.startOnce.Do(func() { go s.run() })
+}
+
+// Stop disconnects from the upstream source and waits for its goroutine to
+// exit. It is safe to call Stop even if Start (or Attach) was never called.
+func (s *Source) Stop() {
+	s.startOnce.Do(func() { close(s.done) })
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// Attach starts the Source, if it is not already running, and forwards every
+// frame it receives to stream. This lets stream serve downstream viewers
+// while keeping a single upstream connection regardless of how many viewers
+// are attached.
+func (s *Source) Attach(stream *Stream) *Source {
+	s.Start()
+	go func() {
+		for frame := range s.frames {
+			stream.UpdateJPEG(frame.JPEG)
+		}
+	}()
+	return s
+}
+
+func (s *Source) run() {
+	defer close(s.done)
+
+	backoff := s.MinBackoff
+	for {
+		connected, err := s.readOnce()
+		if err != nil {
+			slog.Error("Source: read error", "url", s.URL, "error", err)
+		}
+		if connected {
+			backoff = s.MinBackoff
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// readOnce opens a single connection to the upstream source and reads parts
+// from it until the connection is closed or an error occurs. It reports
+// whether the connection was established at all, so the caller can reset its
+// backoff even when the read eventually fails.
+func (s *Source) readOnce() (connected bool, err error) {
+	// ctx is canceled as soon as s.stop fires, which aborts both the dial
+	// and any in-flight read on resp.Body, so Stop() never waits on a
+	// blocked or idle upstream connection.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	if s.Username != "" || s.Password != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if stopped(s.stop) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("mjpeg: unexpected status %s", resp.Status)
+	}
+
+	boundary, err := boundaryFromContentType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	reader := multipart.NewReader(resp.Body, boundary)
+	for {
+		// mime/multipart already copes with both Content-Length-delimited
+		// parts and length-less parts: it scans ahead for the next boundary
+		// line regardless, so this loop is robust to either style of
+		// upstream encoder.
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			if stopped(s.stop) {
+				return true, nil
+			}
+			return true, err
+		}
+
+		jpeg, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			if stopped(s.stop) {
+				return true, nil
+			}
+			return true, err
+		}
+		if len(jpeg) == 0 {
+			continue
+		}
+
+		frame := Frame{JPEG: jpeg, Timestamp: parseTimestamp(part.Header.Get("X-Timestamp"), start)}
+		select {
+		case s.frames <- frame:
+		case <-s.stop:
+			return true, nil
+		}
+	}
+}
+
+// stopped reports whether stop has been closed, without blocking.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// boundaryFromContentType extracts the multipart boundary from a
+// "multipart/x-mixed-replace; boundary=..." Content-Type header.
+func boundaryFromContentType(contentType string) (string, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("mjpeg: parsing Content-Type %q: %w", contentType, err)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return "", errors.New("mjpeg: no multipart boundary in Content-Type")
+	}
+	return boundary, nil
+}
+
+// parseTimestamp parses the "seconds.microseconds" X-Timestamp format
+// written by Stream (see headerf), relative to start. It falls back to the
+// time the part was received if the header is missing or malformed.
+func parseTimestamp(value string, start time.Time) time.Time {
+	sec, frac, ok := strings.Cut(value, ".")
+	if !ok {
+		return time.Now()
+	}
+	s, err1 := strconv.ParseInt(sec, 10, 64)
+	usec, err2 := strconv.ParseInt(frac, 10, 64)
+	if err1 != nil || err2 != nil {
+		return time.Now()
+	}
+	return start.Add(time.Duration(s)*time.Second + time.Duration(usec)*time.Microsecond)
+}
@@ -0,0 +1,118 @@
+package mjpeg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	start := time.Now()
+
+	got := parseTimestamp("15.535000", start)
+	want := start.Add(15*time.Second + 535000*time.Microsecond)
+	if !got.Equal(want) {
+		t.Errorf("parseTimestamp(%q) = %v, want %v", "15.535000", got, want)
+	}
+
+	// Malformed or missing timestamps fall back to "now" rather than erroring.
+	if got := parseTimestamp("", start); got.Before(start) {
+		t.Errorf("parseTimestamp(%q) = %v, want a time no earlier than %v", "", got, start)
+	}
+	if got := parseTimestamp("not-a-timestamp", start); got.Before(start) {
+		t.Errorf("parseTimestamp(%q) = %v, want a time no earlier than %v", "not-a-timestamp", got, start)
+	}
+}
+
+func TestBoundaryFromContentType(t *testing.T) {
+	boundary, err := boundaryFromContentType("multipart/x-mixed-replace; boundary=MJPEGBOUNDARY")
+	if err != nil {
+		t.Fatalf("boundaryFromContentType returned error: %v", err)
+	}
+	if boundary != "MJPEGBOUNDARY" {
+		t.Errorf("boundaryFromContentType() = %q, want %q", boundary, "MJPEGBOUNDARY")
+	}
+
+	if _, err := boundaryFromContentType("multipart/x-mixed-replace"); err == nil {
+		t.Error("boundaryFromContentType() with no boundary param should return an error")
+	}
+}
+
+// TestSourceStopIdleUpstream verifies that Stop returns promptly even when
+// the upstream connection sends one part and then goes idle without closing,
+// which is how a real camera's keep-alive connection behaves.
+func TestSourceStopIdleUpstream(t *testing.T) {
+	blockUntilStop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `multipart/x-mixed-replace; boundary=MJPEGBOUNDARY`)
+		fmt.Fprintf(w, "--MJPEGBOUNDARY\r\nContent-Type: image/jpeg\r\n\r\n%s\r\n--MJPEGBOUNDARY\r\n", "jpeg-bytes")
+		w.(http.Flusher).Flush()
+		<-blockUntilStop
+	}))
+	defer srv.Close()
+	defer close(blockUntilStop)
+
+	s := NewSource(srv.URL)
+	s.Start()
+
+	select {
+	case <-s.Frames():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first frame")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return while upstream connection was idle")
+	}
+}
+
+// TestSourceStartIdempotent verifies that calling Start more than once (as
+// Attach does internally whenever it's itself called more than once) starts
+// only a single read goroutine, rather than double-closing done or splitting
+// frames across two readers.
+func TestSourceStartIdempotent(t *testing.T) {
+	s := NewSource("http://127.0.0.1:0")
+	s.Start()
+	s.Start()
+	s.Start()
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after repeated Start calls")
+	}
+}
+
+// TestSourceStopBeforeStart verifies that Stop returns immediately when
+// called on a Source that was never started.
+func TestSourceStopBeforeStart(t *testing.T) {
+	s := NewSource("http://127.0.0.1:0")
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return for a Source that was never started")
+	}
+}
@@ -9,20 +9,77 @@
 package mjpeg
 
 import (
+	"crypto/sha1"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// BackpressurePolicy controls what a Stream does when a client falls behind
+// and its buffer of undelivered frames fills up.
+type BackpressurePolicy int
+
+const (
+	// DropOldest discards the oldest buffered frame to make room for the new
+	// one. This favors staying close to realtime over frame completeness,
+	// and is the default policy.
+	DropOldest BackpressurePolicy = iota
+	// DropNewest discards the incoming frame, leaving the client's buffer
+	// untouched. This favors frame completeness over staying realtime.
+	DropNewest
+	// CloseOnBackpressure evicts the client as soon as its buffer is full,
+	// on the assumption that a full buffer means the connection is wedged.
+	CloseOnBackpressure
+)
+
 // Stream represents a single video feed.
 type Stream struct {
-	start         time.Time
-	m             map[chan []byte]bool
-	frame         []byte
-	lock          sync.Mutex
+	start        time.Time
+	seq          uint64
+	m            map[*client]bool
+	lastJPEG     []byte
+	lastModified time.Time
+	lock         sync.Mutex
+
 	FrameInterval time.Duration
+
+	// BackpressurePolicy controls what happens when a client can't keep up
+	// with incoming frames. Defaults to DropOldest.
+	BackpressurePolicy BackpressurePolicy
+	// ClientBufferSize is the number of undelivered frames buffered per
+	// client before BackpressurePolicy kicks in. Defaults to 2.
+	ClientBufferSize int
+	// WriteIdleTimeout evicts a client if no frame has been successfully
+	// written to it within the timeout, to catch wedged TCP connections
+	// that neither accept writes nor report an error. Zero disables it.
+	WriteIdleTimeout time.Duration
+}
+
+// client tracks per-connection state for one handler call: its buffered
+// frame channel, an eviction signal, and delivery metrics.
+type client struct {
+	addr   string
+	frames chan frameData
+	evict  chan struct{}
+
+	lock          sync.Mutex
+	framesSent    uint64
+	framesDropped uint64
+	bytesWritten  uint64
+	lastWrite     time.Time
+}
+
+// ClientStats is a point-in-time snapshot of one connected client, returned
+// by Stream.Clients().
+type ClientStats struct {
+	Addr          string
+	FramesSent    uint64
+	FramesDropped uint64
+	BytesWritten  uint64
+	LastWrite     time.Time
 }
 
 const boundaryWord = "MJPEGBOUNDARY"
@@ -33,74 +90,242 @@ const headerf = "\r\n" +
 	"X-Timestamp: %d.%d\r\n" +
 	"\r\n"
 
-// ServeHTTP responds to HTTP requests with the MJPEG stream, implementing the http.Handler interface.
+// ServeHTTP responds to HTTP requests with the MJPEG stream, implementing
+// the http.Handler interface. It is equivalent to
+// stream.HandlerFor(EncoderMultipart).ServeHTTP.
 func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serveHTTPWith(w, r, EncoderMultipart)
+}
+
+// HandlerFor returns an http.Handler that serves frames from this Stream
+// encoded with enc, so the same underlying frame buffer can feed several
+// wire formats (see EncoderMultipart, EncoderJPEGMultipart and
+// EncoderWebSocket).
+func (s *Stream) HandlerFor(enc Encoder) http.Handler {
+	if enc.ContentType() == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.serveWebSocket(w, r, enc)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveHTTPWith(w, r, enc)
+	})
+}
+
+func (s *Stream) serveHTTPWith(w http.ResponseWriter, r *http.Request, enc Encoder) {
 	slog.Info("Stream:", r.RemoteAddr, "connected")
-	w.Header().Add("Content-Type", "multipart/x-mixed-replace;boundary="+boundaryWord)
+	w.Header().Add("Content-Type", enc.ContentType())
 
-	c := make(chan []byte)
-	s.lock.Lock()
-	s.m[c] = true
-	s.lock.Unlock()
-	s.start = time.Now()
+	c := s.addClient(r.RemoteAddr)
+	defer s.removeClient(c)
+
+	rc := http.NewResponseController(w)
 
 	for {
 		time.Sleep(s.FrameInterval)
-		b := <-c
-		_, err := w.Write(b)
+		f, ok := s.nextFrame(c)
+		if !ok {
+			slog.Error("Stream:", r.RemoteAddr, "evicted by backpressure policy or write idle timeout")
+			break
+		}
+
+		if s.WriteIdleTimeout > 0 {
+			rc.SetWriteDeadline(time.Now().Add(s.WriteIdleTimeout))
+		}
+		n, err := w.Write(enc.Encode(f))
 		if err != nil {
 			slog.Error("Stream:%s write error %s", r.RemoteAddr, err.Error())
 			break
 		}
+
+		c.recordWrite(n)
+	}
+
+	slog.Info("Stream:", r.RemoteAddr, "disconnected")
+}
+
+func (s *Stream) addClient(addr string) *client {
+	c := &client{
+		addr:   addr,
+		frames: make(chan frameData, s.bufferSize()),
+		evict:  make(chan struct{}),
 	}
+	s.lock.Lock()
+	s.m[c] = true
+	s.lock.Unlock()
+	s.start = time.Now()
+	return c
+}
 
+func (s *Stream) removeClient(c *client) {
 	s.lock.Lock()
 	delete(s.m, c)
 	s.lock.Unlock()
-	slog.Info("Stream:", r.RemoteAddr, "disconnected")
 }
 
-// UpdateJPEG pushes a new JPEG frame onto the clients.
+func (c *client) recordWrite(n int) {
+	c.lock.Lock()
+	c.framesSent++
+	c.bytesWritten += uint64(n)
+	c.lastWrite = time.Now()
+	c.lock.Unlock()
+}
+
+// nextFrame waits for the next frame queued for c, honoring the Stream's
+// WriteIdleTimeout and reporting false if c was evicted or went idle.
+func (s *Stream) nextFrame(c *client) (frameData, bool) {
+	var timeout <-chan time.Time
+	if s.WriteIdleTimeout > 0 {
+		timeout = time.After(s.WriteIdleTimeout)
+	}
+
+	select {
+	case f := <-c.frames:
+		return f, true
+	case <-c.evict:
+		return frameData{}, false
+	case <-timeout:
+		return frameData{}, false
+	}
+}
+
+// SnapshotHandler returns an http.Handler that serves the most recently
+// received JPEG frame as a single image/jpeg response, for embedding the
+// feed in an <img> tag or polling it from a client that can't consume
+// multipart/x-mixed-replace.
+//
+// The handler never blocks waiting for a new frame: it serves whatever frame
+// is currently buffered, or responds 503 Service Unavailable if no frame has
+// been received yet. It honors If-None-Match and If-Modified-Since so
+// browsers and caching proxies can avoid re-fetching an unchanged frame.
+func (s *Stream) SnapshotHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		jpeg := s.lastJPEG
+		modified := s.lastModified
+		s.lock.Unlock()
+
+		if jpeg == nil {
+			http.Error(w, "no frame available yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		etag := fmt.Sprintf(`"%x"`, sha1.Sum(jpeg))
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if since := r.Header.Get("If-Modified-Since"); since != "" {
+			// HTTP dates have whole-second precision, so compare at that
+			// precision too.
+			if t, err := http.ParseTime(since); err == nil && !modified.Truncate(time.Second).After(t) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(jpeg)))
+		w.Write(jpeg)
+	})
+}
+
+// UpdateJPEG pushes a new JPEG frame onto the clients. The caller's jpeg
+// slice is copied before being queued, so it is safe to reuse the slice
+// (e.g. a bytes.Buffer reset and refilled on every frame) as soon as
+// UpdateJPEG returns.
 func (s *Stream) UpdateJPEG(jpeg []byte) {
 	if len(jpeg) == 0 {
 		return
 	}
-	elapsed := time.Since(s.start)
-	s.updateFrame(jpeg, elapsed)
+
+	buf := make([]byte, len(jpeg))
+	copy(buf, jpeg)
 
 	s.lock.Lock()
+	s.seq++
+	f := frameData{jpeg: buf, elapsed: time.Since(s.start), seq: s.seq}
+	s.lastJPEG = buf
+	s.lastModified = time.Now()
 	for c := range s.m {
-		// Select to skip streams which are sleeping to drop frames.
-		// This might need more thought.
+		s.send(c, f)
+	}
+	s.lock.Unlock()
+}
+
+// send delivers f to c according to the Stream's BackpressurePolicy. It must
+// be called with s.lock held, since CloseOnBackpressure evicts c by removing
+// it from s.m.
+func (s *Stream) send(c *client, f frameData) {
+	select {
+	case c.frames <- f:
+		return
+	default:
+	}
+
+	switch s.BackpressurePolicy {
+	case CloseOnBackpressure:
+		c.lock.Lock()
+		c.framesDropped++
+		c.lock.Unlock()
+		delete(s.m, c)
+		close(c.evict)
+	case DropNewest:
+		c.lock.Lock()
+		c.framesDropped++
+		c.lock.Unlock()
+	default: // DropOldest
+		select {
+		case <-c.frames:
+			c.lock.Lock()
+			c.framesDropped++
+			c.lock.Unlock()
+		default:
+		}
 		select {
-		case c <- s.frame:
+		case c.frames <- f:
 		default:
 		}
 	}
-	s.lock.Unlock()
 }
 
-// NewStream initializes and returns a new Stream.
-func NewStream() *Stream {
-	return &Stream{
-		m:             make(map[chan []byte]bool),
-		frame:         make([]byte, len(headerf)),
-		FrameInterval: 50 * time.Millisecond,
+// Clients returns a point-in-time snapshot of metrics for every currently
+// connected client, so operators can see who is lagging or has gone idle.
+// The order of the returned slice is not meaningful.
+func (s *Stream) Clients() []ClientStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := make([]ClientStats, 0, len(s.m))
+	for c := range s.m {
+		c.lock.Lock()
+		stats = append(stats, ClientStats{
+			Addr:          c.addr,
+			FramesSent:    c.framesSent,
+			FramesDropped: c.framesDropped,
+			BytesWritten:  c.bytesWritten,
+			LastWrite:     c.lastWrite,
+		})
+		c.lock.Unlock()
 	}
+	return stats
 }
 
-func (s *Stream) updateFrame(jpeg []byte, elapsed time.Duration) {
-	header := s.frameHeader(jpeg, elapsed)
-	if len(s.frame) < len(jpeg)+len(header) {
-		s.frame = make([]byte, (len(jpeg)+len(header))*2)
+// bufferSize returns the configured ClientBufferSize, or its default.
+func (s *Stream) bufferSize() int {
+	if s.ClientBufferSize > 0 {
+		return s.ClientBufferSize
 	}
-
-	copy(s.frame, header)
-	copy(s.frame[len(header):], jpeg)
+	return 2
 }
 
-func (s *Stream) frameHeader(jpeg []byte, elapsed time.Duration) string {
-	sec := int64(elapsed.Seconds())
-	usec := int64(elapsed.Microseconds() % 1e6)
-	return fmt.Sprintf(headerf, len(jpeg), sec, usec)
+// NewStream initializes and returns a new Stream.
+func NewStream() *Stream {
+	return &Stream{
+		m:             make(map[*client]bool),
+		FrameInterval: 50 * time.Millisecond,
+	}
 }
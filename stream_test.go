@@ -1,28 +1,69 @@
 package mjpeg
 
 import (
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
-func TestFrameHeader(t *testing.T) {
-	// Create a new Stream instance
-	stream := NewStream()
-
-	// Simulate sending a JPEG frame to the stream
+func TestMultipartEncoderFrame(t *testing.T) {
 	jpegFrame := []byte("test_frame")
 	elapsed := time.Duration(15535 * time.Millisecond)
-	header := stream.frameHeader(jpegFrame, elapsed)
+	frame := EncoderMultipart.Encode(frameData{jpeg: jpegFrame, elapsed: elapsed})
 
-	// Check if the header is correctly formatted
 	expected := "\r\n" +
 		"--MJPEGBOUNDARY\r\n" +
 		"Content-Type: image/jpeg\r\n" +
 		"Content-Length: 10\r\n" +
 		"X-Timestamp: 15.535000\r\n" +
-		"\r\n"
+		"\r\n" +
+		"test_frame"
+
+	if string(frame) != expected {
+		t.Errorf("Expected frame %q, got %q", expected, string(frame))
+	}
+}
+
+func TestSnapshotHandlerNoFrame(t *testing.T) {
+	stream := NewStream()
+
+	req := httptest.NewRequest("GET", "/snapshot", nil)
+	rec := httptest.NewRecorder()
+	stream.SnapshotHandler().ServeHTTP(rec, req)
+
+	if rec.Code != 503 {
+		t.Errorf("Expected status 503 before any frame is received, got %d", rec.Code)
+	}
+}
+
+func TestSnapshotHandlerServesLatestFrame(t *testing.T) {
+	stream := NewStream()
+	stream.UpdateJPEG([]byte("frame-1"))
+
+	req := httptest.NewRequest("GET", "/snapshot", nil)
+	rec := httptest.NewRecorder()
+	stream.SnapshotHandler().ServeHTTP(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Body.String(); got != "frame-1" {
+		t.Errorf("Expected body %q, got %q", "frame-1", got)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Expected Content-Type image/jpeg, got %q", ct)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag header")
+	}
+
+	req2 := httptest.NewRequest("GET", "/snapshot", nil)
+	req2.Header.Set("If-None-Match", etag)
+	rec2 := httptest.NewRecorder()
+	stream.SnapshotHandler().ServeHTTP(rec2, req2)
 
-	if header != expected {
-		t.Errorf("Expected header %s, got %s", expected, header)
+	if rec2.Code != 304 {
+		t.Errorf("Expected status 304 for matching If-None-Match, got %d", rec2.Code)
 	}
 }
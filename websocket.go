@@ -0,0 +1,109 @@
+package mjpeg
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// wsAcceptKey computes the Sec-WebSocket-Accept value for a given
+// Sec-WebSocket-Key, per RFC 6455 section 1.3.
+func wsAcceptKey(key string) string {
+	const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	sum := sha1.Sum([]byte(key + guid))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsHandshake validates and responds to a WebSocket upgrade request,
+// returning the hijacked, now-upgraded connection. It performs only the
+// handshake: Stream serves a one-way stream of binary frames and does not
+// read or respond to client frames (pings, close, etc.) afterwards.
+//
+// hijacked reports whether w was hijacked before the error occurred, so a
+// caller knows not to also try to write an HTTP error response to w: once
+// hijacked, w can no longer be written to as an http.ResponseWriter.
+func wsHandshake(w http.ResponseWriter, r *http.Request) (conn net.Conn, rw *bufio.ReadWriter, hijacked bool, err error) {
+	if r.Header.Get("Upgrade") != "websocket" {
+		return nil, nil, false, fmt.Errorf("mjpeg: expected a WebSocket upgrade request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, nil, false, fmt.Errorf("mjpeg: missing Sec-WebSocket-Key header")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, false, fmt.Errorf("mjpeg: response writer does not support hijacking")
+	}
+	conn, rw, err = hj.Hijack()
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("mjpeg: hijacking connection: %w", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n" +
+		"\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, nil, true, fmt.Errorf("mjpeg: writing handshake response: %w", err)
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, true, fmt.Errorf("mjpeg: flushing handshake response: %w", err)
+	}
+
+	return conn, rw, true, nil
+}
+
+// serveWebSocket upgrades r to a WebSocket connection and streams frames
+// from the Stream to it, encoded with enc, until the connection fails or
+// goes idle. It reuses the same client bookkeeping, backpressure policy, and
+// idle eviction as serveHTTPWith.
+func (s *Stream) serveWebSocket(w http.ResponseWriter, r *http.Request, enc Encoder) {
+	conn, rw, hijacked, err := wsHandshake(w, r)
+	if err != nil {
+		slog.Error("Stream: websocket handshake failed", r.RemoteAddr, err.Error())
+		if !hijacked {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	defer conn.Close()
+
+	slog.Info("Stream:", r.RemoteAddr, "connected")
+
+	c := s.addClient(r.RemoteAddr)
+	defer s.removeClient(c)
+
+	for {
+		time.Sleep(s.FrameInterval)
+		f, ok := s.nextFrame(c)
+		if !ok {
+			slog.Error("Stream:", r.RemoteAddr, "evicted by backpressure policy or write idle timeout")
+			break
+		}
+
+		if s.WriteIdleTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.WriteIdleTimeout))
+		}
+		n, err := rw.Write(enc.Encode(f))
+		if err == nil {
+			err = rw.Flush()
+		}
+		if err != nil {
+			slog.Error("Stream:%s write error %s", r.RemoteAddr, err.Error())
+			break
+		}
+
+		c.recordWrite(n)
+	}
+
+	slog.Info("Stream:", r.RemoteAddr, "disconnected")
+}